@@ -0,0 +1,410 @@
+package trie
+
+import (
+	"container/list"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"sync"
+)
+
+// cachedNode is an in-memory dirty node together with the bookkeeping
+// needed to know when it's safe to flush or garbage-collect it: how many
+// live parents still reference it, and how many references it in turn
+// holds on each of its children. path is the nibble path from the trie's
+// root down to this node, used to namespace its persisted key. spilled
+// marks a node Cap has already flushed to store and subtracted from size;
+// it stays in nodes (and keeps its refcounts) so Dereference can still walk
+// through it, but dereference must not subtract its blob from size again.
+type cachedNode struct {
+	blob     []byte
+	path     []byte
+	parents  int
+	children map[string]int // child hash (hex) -> refs held by this node
+	spilled  bool
+}
+
+// Database is the cache that sits between RadixTrie and the backing
+// NodeStore. Nodes are kept in memory keyed by their hash, with
+// parent/child refcounts, so a caller can hold many in-memory trie
+// revisions cheaply; Commit(root) pushes every node reachable from that
+// root through a single Batch.Write() instead of one round-trip per node,
+// and Dereference(root) drops an old root and garbage-collects whatever
+// becomes unreachable as a result.
+//
+// owner namespaces every persisted key so that several independent tries
+// (e.g. a top-level accounts trie and many per-account storage tries) can
+// share one backing store without their nodes colliding.
+//
+// scheme picks how a node's on-disk key is built (see entryKey). Under
+// PathScheme, reverts records the pre-image of every path touched by each
+// Commit, oldest first, so Rollback can undo recent commits in reverse.
+// Each entry is also mirrored to store under revertLogKey as it's
+// appended, so the undo history survives a process restart; NewDatabaseCache
+// replays it back into reverts on open. The log is capped at maxRevertLog
+// entries: once Commit would grow it past that, the oldest entries are
+// dropped from both reverts and store, so PathScheme's bounded-state-growth
+// goal isn't undercut by an ever-growing undo table. That also bounds how
+// far back Rollback can reach.
+type Database struct {
+	store  NodeStore
+	owner  []byte
+	scheme Scheme
+
+	lock  sync.RWMutex
+	nodes map[string]*cachedNode
+	order *list.List               // dirty node hashes, oldest first
+	elems map[string]*list.Element // hash -> its element in order
+	size  int                      // bytes held by dirty nodes
+
+	reverts   []*revertEntry // PathScheme only
+	revertSeq uint64         // next sequence number to hand out
+}
+
+// revertEntry is the undo log for one Commit under PathScheme: root is the
+// hash that commit produced, diffs maps each touched path to its blob
+// before that commit (nil if the path didn't exist yet), and seq is the
+// sequence number it's persisted under (see revertLogKey).
+type revertEntry struct {
+	root  string
+	diffs map[string][]byte
+	seq   uint64
+}
+
+// revertEntryWire is the JSON encoding of a revertEntry's persisted fields.
+type revertEntryWire struct {
+	Root  string
+	Diffs map[string][]byte
+}
+
+// revertLogPrefix namespaces a Database's persisted revert log rows so they
+// can never collide with a node's entryKey.
+var revertLogPrefix = []byte("trie-revert-log:")
+
+// maxRevertLog bounds how many PathScheme revert entries Commit retains;
+// see the Database doc comment.
+const maxRevertLog = 128
+
+// revertLogKey builds the key revertEntry seq is persisted under, scoped to
+// owner so independent tries sharing a store don't see each other's log.
+func (db *Database) revertLogKey(seq uint64) []byte {
+	buf := make([]byte, 0, len(revertLogPrefix)+len(db.owner)+8)
+	buf = append(buf, revertLogPrefix...)
+	buf = append(buf, db.owner...)
+	seqBuf := make([]byte, 8)
+	binary.BigEndian.PutUint64(seqBuf, seq)
+	return append(buf, seqBuf...)
+}
+
+// loadReverts replays owner's persisted revert log back into db.reverts, in
+// sequence order, and resumes revertSeq past whatever was already used.
+func (db *Database) loadReverts() {
+	prefix := append(append([]byte{}, revertLogPrefix...), db.owner...)
+	it := db.store.Iterator(prefix)
+	defer it.Release()
+	for it.Next() {
+		var wire revertEntryWire
+		if err := json.Unmarshal(it.Value(), &wire); err != nil {
+			continue
+		}
+		key := it.Key()
+		seq := binary.BigEndian.Uint64(key[len(key)-8:])
+		db.reverts = append(db.reverts, &revertEntry{root: wire.Root, diffs: wire.Diffs, seq: seq})
+		if seq >= db.revertSeq {
+			db.revertSeq = seq + 1
+		}
+	}
+}
+
+// NewDatabaseCache wraps store with a dirty node cache namespaced to owner,
+// keying persisted nodes according to scheme. Under PathScheme it also
+// replays any revert log previously persisted for owner.
+func NewDatabaseCache(store NodeStore, owner []byte, scheme Scheme) *Database {
+	db := &Database{
+		store:  store,
+		owner:  owner,
+		scheme: scheme,
+		nodes:  make(map[string]*cachedNode),
+		order:  list.New(),
+		elems:  make(map[string]*list.Element),
+	}
+	if scheme == PathScheme {
+		db.loadReverts()
+	}
+	return db
+}
+
+// entryKey builds the key a node is persisted under. Under HashScheme that's
+// owner || path || hash, so every distinct value at a path gets its own row;
+// under PathScheme the hash is dropped (owner || path), so a later write to
+// the same path overwrites it instead of accumulating a new row.
+func (db *Database) entryKey(path []byte, hash string) []byte {
+	if db.scheme == PathScheme {
+		buf := make([]byte, 0, len(db.owner)+len(path))
+		buf = append(buf, db.owner...)
+		buf = append(buf, path...)
+		return buf
+	}
+	h, _ := hex.DecodeString(hash)
+	buf := make([]byte, 0, len(db.owner)+len(path)+len(h))
+	buf = append(buf, db.owner...)
+	buf = append(buf, path...)
+	buf = append(buf, h...)
+	return buf
+}
+
+// insert registers a dirty node under its hash and records that it
+// references every hash in refs, bumping each referenced node's parent
+// count. Re-inserting an already-dirty hash just refreshes its blob.
+func (db *Database) insert(hash string, blob []byte, refs []string, path []byte) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	if n, ok := db.nodes[hash]; ok {
+		n.blob = blob
+		n.path = path
+		return
+	}
+	n := &cachedNode{blob: blob, path: path, children: make(map[string]int)}
+	for _, ref := range refs {
+		n.children[ref]++
+		if child, ok := db.nodes[ref]; ok {
+			child.parents++
+		}
+	}
+	db.nodes[hash] = n
+	db.elems[hash] = db.order.PushBack(hash)
+	db.size += len(blob)
+}
+
+// node returns the blob for hash, checking the dirty cache before falling
+// back to the backing store. Once a node has been flushed it is only
+// findable on disk by its full namespaced entryKey, so a miss in the dirty
+// cache here is only resolvable by a caller that also knows the node's
+// path.
+func (db *Database) node(hash string, path []byte) ([]byte, error) {
+	db.lock.RLock()
+	if n, ok := db.nodes[hash]; ok {
+		db.lock.RUnlock()
+		return n.blob, nil
+	}
+	db.lock.RUnlock()
+
+	return db.store.Get(db.entryKey(path, hash))
+}
+
+// Dereference drops root's reference and garbage-collects any node whose
+// refcount falls to zero as a result, recursing into its children.
+func (db *Database) Dereference(root string) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	db.dereference(root)
+}
+
+func (db *Database) dereference(hash string) {
+	n, ok := db.nodes[hash]
+	if !ok {
+		return
+	}
+	n.parents--
+	if n.parents > 0 {
+		return
+	}
+	for child := range n.children {
+		db.dereference(child)
+	}
+	delete(db.nodes, hash)
+	if elem, ok := db.elems[hash]; ok {
+		db.order.Remove(elem)
+		delete(db.elems, hash)
+	}
+	if !n.spilled {
+		db.size -= len(n.blob)
+	}
+}
+
+// Commit pushes every node reachable from root, plus the removal of every
+// path in deletedPaths, through a single Batch.Write() to the backing
+// store. Nodes stay in the dirty cache afterwards; callers that are done
+// with an old revision should Dereference its root separately.
+//
+// deletedPaths is only meaningful under PathScheme (HashScheme never
+// removes a row, since a node's key already encodes its content); under
+// HashScheme it's ignored. Under PathScheme, the blob living at each
+// touched path before this commit is recorded in the revert log so
+// Rollback can restore it later.
+func (db *Database) Commit(root string, deletedPaths [][]byte) error {
+	db.lock.RLock()
+	seen := make(map[string]bool)
+	var hashes []string
+	var walk func(string)
+	walk = func(h string) {
+		if seen[h] {
+			return
+		}
+		seen[h] = true
+		n, ok := db.nodes[h]
+		if !ok {
+			return
+		}
+		hashes = append(hashes, h)
+		for child := range n.children {
+			walk(child)
+		}
+	}
+	walk(root)
+	db.lock.RUnlock()
+
+	batch := db.store.NewBatch()
+	var diffs map[string][]byte
+	if db.scheme == PathScheme {
+		diffs = make(map[string][]byte)
+	}
+	for _, h := range hashes {
+		db.lock.RLock()
+		n := db.nodes[h]
+		db.lock.RUnlock()
+		if n == nil {
+			continue
+		}
+		key := db.entryKey(n.path, h)
+		if db.scheme == PathScheme {
+			diffs[string(n.path)], _ = db.store.Get(key)
+		}
+		if err := batch.Put(key, n.blob); err != nil {
+			return err
+		}
+	}
+	if db.scheme == PathScheme {
+		for _, path := range deletedPaths {
+			key := db.entryKey(path, "")
+			diffs[string(path)], _ = db.store.Get(key)
+			if err := batch.Delete(key); err != nil {
+				return err
+			}
+		}
+	}
+	var entry *revertEntry
+	var evicted []*revertEntry
+	if db.scheme == PathScheme {
+		db.lock.RLock()
+		seq := db.revertSeq
+		if over := len(db.reverts) + 1 - maxRevertLog; over > 0 {
+			evicted = append(evicted, db.reverts[:over]...)
+		}
+		db.lock.RUnlock()
+		entry = &revertEntry{root: root, diffs: diffs, seq: seq}
+		blob, err := json.Marshal(revertEntryWire{Root: entry.root, Diffs: entry.diffs})
+		if err != nil {
+			return err
+		}
+		if err := batch.Put(db.revertLogKey(seq), blob); err != nil {
+			return err
+		}
+		for _, old := range evicted {
+			if err := batch.Delete(db.revertLogKey(old.seq)); err != nil {
+				return err
+			}
+		}
+	}
+	if err := batch.Write(); err != nil {
+		return err
+	}
+	if db.scheme == PathScheme {
+		db.lock.Lock()
+		db.reverts = append(db.reverts, entry)
+		if len(db.reverts) > maxRevertLog {
+			db.reverts = db.reverts[len(db.reverts)-maxRevertLog:]
+		}
+		db.revertSeq++
+		db.lock.Unlock()
+	}
+	return nil
+}
+
+// Rollback undoes every commit made since root was produced, restoring
+// each path it touched to its blob from before that commit (or deleting
+// it, if it didn't exist yet). Only supported under PathScheme: under
+// HashScheme a node is never overwritten, so an old root is already
+// reachable on its own without needing a revert log.
+func (db *Database) Rollback(root string) error {
+	if db.scheme != PathScheme {
+		return errors.New("trie: Rollback requires PathScheme")
+	}
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	idx := -1
+	if root != "" {
+		for i := len(db.reverts) - 1; i >= 0; i-- {
+			if db.reverts[i].root == root {
+				idx = i
+				break
+			}
+		}
+		if idx == -1 {
+			return errors.New("trie: root not found in revert history")
+		}
+	}
+
+	batch := db.store.NewBatch()
+	for i := len(db.reverts) - 1; i > idx; i-- {
+		for path, prev := range db.reverts[i].diffs {
+			key := db.entryKey([]byte(path), "")
+			if prev == nil {
+				if err := batch.Delete(key); err != nil {
+					return err
+				}
+			} else if err := batch.Put(key, prev); err != nil {
+				return err
+			}
+		}
+		if err := batch.Delete(db.revertLogKey(db.reverts[i].seq)); err != nil {
+			return err
+		}
+	}
+	if err := batch.Write(); err != nil {
+		return err
+	}
+	db.reverts = db.reverts[:idx+1]
+	return nil
+}
+
+// Cap spills the oldest dirty nodes to disk until the in-memory dirty set
+// is back under limit bytes, in one batch. Spilled nodes stay in the
+// cache (and keep their refcounts) so reads are unaffected; this only
+// bounds memory use between commits.
+func (db *Database) Cap(limit int) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	batch := db.store.NewBatch()
+	var spilled []*list.Element
+	for db.size > limit {
+		elem := db.order.Front()
+		if elem == nil {
+			break
+		}
+		h := elem.Value.(string)
+		n := db.nodes[h]
+		if n == nil {
+			db.order.Remove(elem)
+			continue
+		}
+		if err := batch.Put(db.entryKey(n.path, h), n.blob); err != nil {
+			return err
+		}
+		db.size -= len(n.blob)
+		n.spilled = true
+		spilled = append(spilled, elem)
+	}
+	if err := batch.Write(); err != nil {
+		return err
+	}
+	for _, elem := range spilled {
+		delete(db.elems, elem.Value.(string))
+		db.order.Remove(elem)
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package trie
+
+import "errors"
+
+// This file decodes the RLP node encoding produced by node.go, needed to
+// turn the blobs in a RangeProof back into a node tree during
+// VerifyRangeProof.
+
+// rlpItem is a decoded RLP item: a byte string (list == nil) or a list of
+// items.
+type rlpItem struct {
+	str  []byte
+	list []rlpItem
+}
+
+func rlpDecode(b []byte) (rlpItem, []byte, error) {
+	if len(b) == 0 {
+		return rlpItem{}, nil, errors.New("rlp: empty input")
+	}
+	prefix := b[0]
+	switch {
+	case prefix < 0x80:
+		return rlpItem{str: b[:1]}, b[1:], nil
+	case prefix < 0xb8:
+		n := int(prefix - 0x80)
+		if len(b) < 1+n {
+			return rlpItem{}, nil, errors.New("rlp: short string")
+		}
+		return rlpItem{str: b[1 : 1+n]}, b[1+n:], nil
+	case prefix < 0xc0:
+		ll := int(prefix - 0xb7)
+		if len(b) < 1+ll {
+			return rlpItem{}, nil, errors.New("rlp: long string length")
+		}
+		n := int(bigEndianToUint(b[1 : 1+ll]))
+		start := 1 + ll
+		if len(b) < start+n {
+			return rlpItem{}, nil, errors.New("rlp: long string")
+		}
+		return rlpItem{str: b[start : start+n]}, b[start+n:], nil
+	case prefix < 0xf8:
+		n := int(prefix - 0xc0)
+		if len(b) < 1+n {
+			return rlpItem{}, nil, errors.New("rlp: short list")
+		}
+		items, err := rlpDecodeList(b[1 : 1+n])
+		if err != nil {
+			return rlpItem{}, nil, err
+		}
+		return rlpItem{list: items}, b[1+n:], nil
+	default:
+		ll := int(prefix - 0xf7)
+		if len(b) < 1+ll {
+			return rlpItem{}, nil, errors.New("rlp: long list length")
+		}
+		n := int(bigEndianToUint(b[1 : 1+ll]))
+		start := 1 + ll
+		if len(b) < start+n {
+			return rlpItem{}, nil, errors.New("rlp: long list")
+		}
+		items, err := rlpDecodeList(b[start : start+n])
+		if err != nil {
+			return rlpItem{}, nil, err
+		}
+		return rlpItem{list: items}, b[start+n:], nil
+	}
+}
+
+func rlpDecodeList(body []byte) ([]rlpItem, error) {
+	var items []rlpItem
+	for len(body) > 0 {
+		item, rest, err := rlpDecode(body)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+		body = rest
+	}
+	return items, nil
+}
+
+func bigEndianToUint(b []byte) uint64 {
+	var n uint64
+	for _, by := range b {
+		n = n<<8 | uint64(by)
+	}
+	return n
+}
+
+// decodeNode parses the RLP encoding of a branch, extension or leaf node
+// (as produced by node.go's encode methods) back into a node.
+func decodeNode(blob []byte) (node, error) {
+	item, _, err := rlpDecode(blob)
+	if err != nil {
+		return nil, err
+	}
+	return decodeNodeItem(item)
+}
+
+func decodeNodeItem(item rlpItem) (node, error) {
+	if item.list == nil {
+		return nil, errors.New("trie: expected a list-encoded node")
+	}
+	switch len(item.list) {
+	case 2:
+		path := compactToHex(item.list[0].str)
+		if hasTerm(path) {
+			return &leafNode{path: path[:len(path)-1], value: item.list[1].str}, nil
+		}
+		child, err := decodeRef(item.list[1])
+		if err != nil {
+			return nil, err
+		}
+		return &extensionNode{path: path, child: child}, nil
+	case 17:
+		var bn branchNode
+		for i := 0; i < 16; i++ {
+			child, err := decodeRef(item.list[i])
+			if err != nil {
+				return nil, err
+			}
+			bn.children[i] = child
+		}
+		if len(item.list[16].str) > 0 {
+			bn.value = item.list[16].str
+		}
+		return &bn, nil
+	default:
+		return nil, errors.New("trie: invalid node RLP")
+	}
+}
+
+// decodeRef turns a decoded child reference item into nil (no child), an
+// embedded node, or a hashNode.
+func decodeRef(item rlpItem) (node, error) {
+	if item.list != nil {
+		return decodeNodeItem(item)
+	}
+	if len(item.str) == 0 {
+		return nil, nil
+	}
+	return hashNode(item.str), nil
+}
@@ -0,0 +1,93 @@
+package trie
+
+// This file implements the hex-nibble and hex-prefix (HP/"compact") encodings
+// used throughout the trie: keys are worked on as nibbles internally, but are
+// packed back into bytes (compact encoding) whenever they are written into a
+// leaf or extension node, exactly as the Ethereum Yellow Paper specifies.
+
+// keybytesToHex splits a byte key into nibbles and appends the terminator
+// nibble (16) that marks "this path ends in a value".
+func keybytesToHex(key []byte) []byte {
+	l := len(key)*2 + 1
+	nibbles := make([]byte, l)
+	for i, b := range key {
+		nibbles[i*2] = b / 16
+		nibbles[i*2+1] = b % 16
+	}
+	nibbles[l-1] = 16
+	return nibbles
+}
+
+// hexToKeybytes is the inverse of keybytesToHex; it panics if the (stripped)
+// nibble slice has odd length, since that can never come from a real key.
+func hexToKeybytes(hex []byte) []byte {
+	if hasTerm(hex) {
+		hex = hex[:len(hex)-1]
+	}
+	if len(hex)&1 != 0 {
+		panic("can't convert hex key of odd length")
+	}
+	key := make([]byte, len(hex)/2)
+	decodeNibbles(hex, key)
+	return key
+}
+
+func decodeNibbles(nibbles []byte, bytes []byte) {
+	for bi, ni := 0, 0; ni < len(nibbles); bi, ni = bi+1, ni+2 {
+		bytes[bi] = nibbles[ni]<<4 | nibbles[ni+1]
+	}
+}
+
+// hexToCompact packs hex nibbles into the HP encoding used on the wire: the
+// high nibble of the first byte carries the terminator flag (bit 1) and the
+// odd-length flag (bit 0); an odd number of nibbles borrows the first nibble
+// into that same byte so the rest stays byte-aligned.
+func hexToCompact(hex []byte) []byte {
+	terminator := byte(0)
+	if hasTerm(hex) {
+		terminator = 1
+		hex = hex[:len(hex)-1]
+	}
+	buf := make([]byte, len(hex)/2+1)
+	buf[0] = terminator << 5
+	if len(hex)&1 == 1 {
+		buf[0] |= 1 << 4
+		buf[0] |= hex[0]
+		hex = hex[1:]
+	}
+	decodeNibbles(hex, buf[1:])
+	return buf
+}
+
+// compactToHex is the inverse of hexToCompact.
+func compactToHex(compact []byte) []byte {
+	if len(compact) == 0 {
+		return compact
+	}
+	base := keybytesToHex(compact)
+	// delete terminator flag
+	if base[0] < 2 {
+		base = base[:len(base)-1]
+	}
+	chop := 2 - base[0]&1
+	return base[chop:]
+}
+
+// hasTerm reports whether a hex nibble slice ends in the terminator nibble.
+func hasTerm(s []byte) bool {
+	return len(s) > 0 && s[len(s)-1] == 16
+}
+
+// prefixLen returns the length of the common nibble prefix of a and b.
+func prefixLen(a, b []byte) int {
+	i, n := 0, len(a)
+	if len(b) < n {
+		n = len(b)
+	}
+	for ; i < n; i++ {
+		if a[i] != b[i] {
+			break
+		}
+	}
+	return i
+}
@@ -0,0 +1,80 @@
+package trie
+
+import "golang.org/x/crypto/sha3"
+
+// node is satisfied by every node kind that can appear in the trie,
+// including hashNode, which stands in for a child that has been committed
+// and is only known by its hash.
+type node interface {
+	encode() []byte
+}
+
+// branchNode is the 16-way fan-out node, keyed by the next nibble of the
+// key, with a 17th slot for a value whose key ends exactly at this node.
+type branchNode struct {
+	children [16]node
+	value    []byte
+}
+
+// extensionNode shares a nibble path with a single child, collapsing what
+// would otherwise be a run of one-child branch nodes.
+type extensionNode struct {
+	path  []byte // nibbles, never includes the terminator
+	child node
+}
+
+// leafNode holds the remaining nibble path for a key together with its
+// value.
+type leafNode struct {
+	path  []byte // nibbles, terminator implied
+	value []byte
+}
+
+// hashNode is a reference to a node that has already been committed,
+// addressed by its keccak256 hash. It appears in nodes decoded from their
+// RLP encoding (see decodeNode); Commit does not replace in-memory nodes
+// with hashNodes.
+type hashNode []byte
+
+func (n hashNode) encode() []byte { return n }
+
+func (n *branchNode) encode() []byte {
+	items := make([][]byte, 17)
+	for i, c := range n.children {
+		items[i] = encodeChild(c)
+	}
+	items[16] = rlpEncodeBytes(n.value)
+	return rlpEncodeList(items...)
+}
+
+func (n *extensionNode) encode() []byte {
+	return rlpEncodeList(rlpEncodeBytes(hexToCompact(n.path)), encodeChild(n.child))
+}
+
+func (n *leafNode) encode() []byte {
+	path := append(append([]byte{}, n.path...), 16)
+	return rlpEncodeList(rlpEncodeBytes(hexToCompact(path)), rlpEncodeBytes(n.value))
+}
+
+// encodeChild returns the RLP item used to reference a child: the child's
+// own RLP if it's short enough to embed (<32 bytes), otherwise its
+// keccak256 hash.
+func encodeChild(n node) []byte {
+	if n == nil {
+		return rlpEncodeBytes(nil)
+	}
+	if hn, ok := n.(hashNode); ok {
+		return rlpEncodeBytes(hn)
+	}
+	enc := n.encode()
+	if len(enc) < 32 {
+		return enc
+	}
+	return rlpEncodeBytes(keccak256(enc))
+}
+
+func keccak256(data []byte) []byte {
+	h := sha3.NewLegacyKeccak256()
+	h.Write(data)
+	return h.Sum(nil)
+}
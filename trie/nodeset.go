@@ -0,0 +1,62 @@
+package trie
+
+// NodeSet records everything that changed in a trie during one Commit:
+// every node inserted or updated, keyed by its path, together with its
+// hash and RLP blob; every node deleted, keyed by its old path, together
+// with the hash it used to have; and, if requested, every (key, value)
+// pair reachable in the committed trie. A caller can feed a NodeSet into a
+// Database for atomic apply, ship it over the wire for state sync, or keep
+// it as a reverse diff for pruning/rollback.
+type NodeSet struct {
+	Owner   []byte
+	Inserts map[string]*NodeInsert // path (nibbles, as a raw string) -> node
+	Deletes map[string][]byte      // path (nibbles, as a raw string) -> previous hash
+	Leaves  []LeafInsert           // only populated when Commit(collectLeaf=true)
+}
+
+// NodeInsert is one inserted or updated node.
+type NodeInsert struct {
+	Hash []byte
+	Blob []byte
+}
+
+// LeafInsert is one (key, value) pair reachable in the committed trie.
+type LeafInsert struct {
+	Key   []byte
+	Value []byte
+}
+
+func newNodeSet(owner []byte) *NodeSet {
+	return &NodeSet{
+		Owner:   owner,
+		Inserts: make(map[string]*NodeInsert),
+		Deletes: make(map[string][]byte),
+	}
+}
+
+// MergeSingle folds set into the receiver, namespacing its paths by owner
+// so sets from independent tries (e.g. an accounts trie and several
+// storage tries) don't collide. This lets several subtrie commits be
+// batched into one apply/DB write.
+func (ns *NodeSet) MergeSingle(set *NodeSet) {
+	if set == nil {
+		return
+	}
+	for path, ins := range set.Inserts {
+		ns.Inserts[string(set.Owner)+"\x00"+path] = ins
+	}
+	for path, hash := range set.Deletes {
+		ns.Deletes[string(set.Owner)+"\x00"+path] = hash
+	}
+	ns.Leaves = append(ns.Leaves, set.Leaves...)
+}
+
+// Merge combines several NodeSets into one, ready for a single atomic
+// apply.
+func Merge(sets ...*NodeSet) *NodeSet {
+	merged := newNodeSet(nil)
+	for _, set := range sets {
+		merged.MergeSingle(set)
+	}
+	return merged
+}
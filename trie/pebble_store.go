@@ -0,0 +1,123 @@
+package trie
+
+import (
+	"bytes"
+
+	"github.com/cockroachdb/pebble"
+)
+
+// pebbleStore is a NodeStore backed by Pebble (an embedded LevelDB-style
+// KV store), a much better fit for a hash->blob workload than row-per-node
+// SQL inserts: writes batch naturally and reads are a single point lookup.
+type pebbleStore struct {
+	db *pebble.DB
+}
+
+// NewPebbleStore opens (creating if necessary) a Pebble database at dir as
+// a NodeStore.
+func NewPebbleStore(dir string) (NodeStore, error) {
+	db, err := pebble.Open(dir, &pebble.Options{})
+	if err != nil {
+		return nil, err
+	}
+	return &pebbleStore{db: db}, nil
+}
+
+func (s *pebbleStore) Get(hash []byte) ([]byte, error) {
+	v, closer, err := s.db.Get(hash)
+	if err != nil {
+		return nil, err
+	}
+	defer closer.Close()
+	out := make([]byte, len(v))
+	copy(out, v)
+	return out, nil
+}
+
+func (s *pebbleStore) Put(hash, blob []byte) error {
+	return s.db.Set(hash, blob, pebble.Sync)
+}
+
+func (s *pebbleStore) Delete(hash []byte) error {
+	return s.db.Delete(hash, pebble.Sync)
+}
+
+func (s *pebbleStore) NewBatch() Batch {
+	return &pebbleBatch{batch: s.db.NewBatch()}
+}
+
+func (s *pebbleStore) Iterator(prefix []byte) Iterator {
+	iter, err := s.db.NewIter(&pebble.IterOptions{
+		LowerBound: prefix,
+		UpperBound: prefixUpperBound(prefix),
+	})
+	if err != nil {
+		return &pebbleIterator{err: err}
+	}
+	return &pebbleIterator{iter: iter, atStart: true}
+}
+
+// prefixUpperBound returns the smallest key that sorts after every key
+// starting with prefix, for use as a Pebble iterator's UpperBound.
+func prefixUpperBound(prefix []byte) []byte {
+	upper := append([]byte{}, prefix...)
+	for i := len(upper) - 1; i >= 0; i-- {
+		if upper[i] < 0xff {
+			upper[i]++
+			return upper[:i+1]
+		}
+	}
+	return nil // prefix is all 0xff, or empty: unbounded
+}
+
+type pebbleBatch struct {
+	batch *pebble.Batch
+}
+
+func (b *pebbleBatch) Put(hash, blob []byte) error { return b.batch.Set(hash, blob, nil) }
+func (b *pebbleBatch) Delete(hash []byte) error     { return b.batch.Delete(hash, nil) }
+func (b *pebbleBatch) Write() error                 { return b.batch.Commit(pebble.Sync) }
+func (b *pebbleBatch) Reset()                       { b.batch.Reset() }
+
+type pebbleIterator struct {
+	iter    *pebble.Iterator
+	atStart bool
+	err     error
+}
+
+func (it *pebbleIterator) Next() bool {
+	if it.err != nil || it.iter == nil {
+		return false
+	}
+	if it.atStart {
+		it.atStart = false
+		return it.iter.First()
+	}
+	return it.iter.Next()
+}
+
+func (it *pebbleIterator) Key() []byte   { return cloneBytes(it.iter.Key()) }
+func (it *pebbleIterator) Value() []byte { return cloneBytes(it.iter.Value()) }
+
+func (it *pebbleIterator) Error() error {
+	if it.err != nil {
+		return it.err
+	}
+	if it.iter == nil {
+		return nil
+	}
+	return it.iter.Error()
+}
+
+func (it *pebbleIterator) Release() {
+	if it.iter != nil {
+		it.iter.Close()
+	}
+}
+
+func cloneBytes(b []byte) []byte {
+	if b == nil {
+		return nil
+	}
+	return bytes.Clone(b)
+}
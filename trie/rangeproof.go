@@ -0,0 +1,359 @@
+package trie
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+)
+
+// RangeProof returns a proof that keys/values are exactly the contents of
+// the trie between first and last, inclusive. Either bound may be nil,
+// meaning unbounded on that side (both nil proves the whole trie). The
+// proof is the RLP encoding of every node on the path from the root down
+// to first and down to last; each branch/extension node along those paths
+// already carries the hash of every sibling it didn't walk into, so that's
+// enough for VerifyRangeProof to rebuild a partial trie and check it
+// against the root. A remote peer can use this to hand a receiver a swath
+// of state that the receiver only needs the root hash to trust.
+func (t *RadixTrie) RangeProof(first, last []byte) (proof [][]byte, keys, values [][]byte, err error) {
+	seen := make(map[string]bool)
+	add := func(nodes [][]byte) {
+		for _, n := range nodes {
+			k := string(n)
+			if !seen[k] {
+				seen[k] = true
+				proof = append(proof, n)
+			}
+		}
+	}
+	if first != nil {
+		add(pathNodes(t.root, keyToNibbles(first)))
+	}
+	if last != nil {
+		add(pathNodes(t.root, keyToNibbles(last)))
+	}
+
+	collectRange(t.root, nil, first, last, &keys, &values)
+	return proof, keys, values, nil
+}
+
+// pathNodes returns the RLP encoding of every node from the root down to
+// wherever key's path runs out. Running out before key is exhausted is
+// itself a valid non-existence proof, so this never errors.
+func pathNodes(n node, key []byte) [][]byte {
+	var out [][]byte
+	for {
+		switch cur := n.(type) {
+		case nil:
+			return out
+		case *leafNode:
+			out = append(out, cur.encode())
+			return out
+		case *extensionNode:
+			out = append(out, cur.encode())
+			match := prefixLen(key, cur.path)
+			if match < len(cur.path) {
+				return out
+			}
+			key = key[match:]
+			n = cur.child
+		case *branchNode:
+			out = append(out, cur.encode())
+			if len(key) == 0 {
+				return out
+			}
+			n = cur.children[key[0]]
+			key = key[1:]
+		}
+	}
+}
+
+// collectRange appends every (key, value) pair reachable under n, in key
+// order, whose key falls within [first, last] (a nil bound is unbounded on
+// that side).
+func collectRange(n node, prefix, first, last []byte, keys, values *[][]byte) {
+	switch n := n.(type) {
+	case nil:
+		return
+	case *leafNode:
+		key := hexToKeybytes(concatNibbles(prefix, n.path))
+		if inRange(key, first, last) {
+			*keys = append(*keys, key)
+			*values = append(*values, n.value)
+		}
+	case *extensionNode:
+		collectRange(n.child, concatNibbles(prefix, n.path), first, last, keys, values)
+	case *branchNode:
+		if n.value != nil {
+			key := hexToKeybytes(prefix)
+			if inRange(key, first, last) {
+				*keys = append(*keys, key)
+				*values = append(*values, n.value)
+			}
+		}
+		for i, c := range n.children {
+			if c != nil {
+				collectRange(c, concatNibbles(prefix, []byte{byte(i)}), first, last, keys, values)
+			}
+		}
+	}
+}
+
+func inRange(key, first, last []byte) bool {
+	if first != nil && bytes.Compare(key, first) < 0 {
+		return false
+	}
+	if last != nil && bytes.Compare(key, last) > 0 {
+		return false
+	}
+	return true
+}
+
+// VerifyRangeProof checks that proof (the boundary nodes from RangeProof)
+// together with keys/values (every pair claimed to lie between first and
+// last) reconstructs root. It rebuilds a partial trie by decoding proof
+// into a node skeleton — any child it doesn't have a proof node for stays
+// a hashNode "shortcut" standing in for an untouched subtree — then
+// inserts every (key, value) pair into that skeleton and hashes the
+// result. It returns more=true iff the skeleton shows there could be
+// additional keys beyond last.
+func VerifyRangeProof(root, first, last []byte, keys, values [][]byte, proof [][]byte) (more bool, err error) {
+	if len(keys) != len(values) {
+		return false, errors.New("trie: keys/values length mismatch")
+	}
+	for i := 1; i < len(keys); i++ {
+		if bytes.Compare(keys[i-1], keys[i]) >= 0 {
+			return false, errors.New("trie: range proof keys out of order")
+		}
+	}
+
+	byHash := make(map[string]node, len(proof))
+	for _, blob := range proof {
+		n, err := decodeNode(blob)
+		if err != nil {
+			return false, err
+		}
+		byHash[hex.EncodeToString(keccak256(blob))] = n
+	}
+
+	var built node
+	if rootNode, ok := byHash[hex.EncodeToString(root)]; ok {
+		built = resolve(rootNode, byHash)
+	} else if len(proof) != 0 {
+		return false, errors.New("trie: proof does not contain the root")
+	}
+	// an empty proof gives no skeleton: reconstruction is then a plain
+	// from-scratch build, which only matches root if keys/values hold every
+	// key in the trie (as for first == last == nil, or an empty trie).
+
+	if first != nil && last != nil {
+		// Every subtree strictly between the two boundary paths is wholly
+		// inside [first, last], so the prover must supply all of its keys
+		// fresh; drop its hashNode shortcut so a key missing from keys
+		// changes the reconstructed root instead of the stale hash
+		// silently still matching.
+		built = unsetInternal(built, keyToNibbles(first), keyToNibbles(last))
+	}
+
+	for i, key := range keys {
+		built = insert(built, keyToNibbles(key), values[i])
+	}
+
+	var builtRoot []byte
+	if built == nil {
+		builtRoot = emptyRootHash()
+	} else {
+		builtRoot = keccak256(built.encode())
+	}
+	if !bytes.Equal(builtRoot, root) {
+		return false, errors.New("trie: range proof does not reconstruct the expected root")
+	}
+
+	if last == nil {
+		return false, nil
+	}
+	return hasMore(built, keyToNibbles(last)), nil
+}
+
+// resolve replaces every hashNode child of n that appears in byHash with
+// its decoded node (recursively), leaving any hash it has no proof for as
+// an opaque shortcut.
+func resolve(n node, byHash map[string]node) node {
+	switch n := n.(type) {
+	case *branchNode:
+		for i, c := range n.children {
+			n.children[i] = resolveChild(c, byHash)
+		}
+		return n
+	case *extensionNode:
+		n.child = resolveChild(n.child, byHash)
+		return n
+	default:
+		return n
+	}
+}
+
+func resolveChild(n node, byHash map[string]node) node {
+	hn, ok := n.(hashNode)
+	if !ok {
+		return n
+	}
+	if resolved, ok := byHash[hex.EncodeToString(hn)]; ok {
+		return resolve(resolved, byHash)
+	}
+	return n
+}
+
+// unsetInternal walks n down the shared prefix of left and right (the
+// nibble paths of the range's two boundary keys) until they diverge at a
+// branch, then drops (sets to nil) every child strictly between them: that
+// child's whole subtree lies inside [left, right], so every key under it
+// must come from the supplied keys/values rather than the proof's stale
+// hashNode reference. The two boundary children themselves are handed to
+// unsetLeft/unsetRight, which continue the same pruning one level down
+// along each boundary's own path. Anything outside [left, right] is left
+// untouched: it's legitimately unsupplied, and its hash is never expected
+// to be independently verified.
+func unsetInternal(n node, left, right []byte) node {
+	switch n := n.(type) {
+	case *extensionNode:
+		match := prefixLen(left, n.path)
+		n.child = unsetInternal(n.child, left[match:], right[match:])
+		return n
+	case *branchNode:
+		li, ri := -1, -1
+		if len(left) > 0 {
+			li = int(left[0])
+		}
+		if len(right) > 0 {
+			ri = int(right[0])
+		}
+		lo, hi := 0, 16
+		if li >= 0 {
+			lo = li + 1
+		}
+		if ri >= 0 {
+			hi = ri
+		}
+		for i := lo; i < hi; i++ {
+			n.children[i] = nil
+		}
+		switch {
+		case li >= 0 && li == ri:
+			n.children[li] = unsetInternal(n.children[li], left[1:], right[1:])
+		default:
+			if li >= 0 {
+				n.children[li] = unsetLeft(n.children[li], left[1:])
+			}
+			if ri >= 0 {
+				n.children[ri] = unsetRight(n.children[ri], right[1:])
+			}
+		}
+		return n
+	default:
+		return n
+	}
+}
+
+// unsetLeft descends along the left boundary's own path below the fork,
+// dropping every sibling greater than it at each level: those keys are
+// greater than the left boundary but (being left of the fork) still less
+// than the right one, so they too are wholly interior. Reaching the end of
+// left's path (a shorter key than any of this branch's children) means
+// every child here is interior, so all of them are dropped.
+func unsetLeft(n node, left []byte) node {
+	switch n := n.(type) {
+	case *extensionNode:
+		match := prefixLen(left, n.path)
+		if match < len(n.path) {
+			if match < len(left) && n.path[match] < left[match] {
+				return n // sorts before left: out of range
+			}
+			return nil
+		}
+		n.child = unsetLeft(n.child, left[match:])
+		return n
+	case *branchNode:
+		if len(left) == 0 {
+			for i := range n.children {
+				n.children[i] = nil
+			}
+			return n
+		}
+		idx := left[0]
+		for i := int(idx) + 1; i < 16; i++ {
+			n.children[i] = nil
+		}
+		n.children[idx] = unsetLeft(n.children[idx], left[1:])
+		return n
+	default:
+		return n
+	}
+}
+
+// unsetRight is unsetLeft's mirror image along the right boundary's path:
+// every sibling less than it at each level is interior and gets dropped.
+// Reaching the end of right's path means every child here is longer (and
+// so greater) than the right boundary, hence out of range, and is left
+// alone.
+func unsetRight(n node, right []byte) node {
+	switch n := n.(type) {
+	case *extensionNode:
+		match := prefixLen(right, n.path)
+		if match < len(n.path) {
+			if match < len(right) && n.path[match] < right[match] {
+				return nil // sorts before right: interior
+			}
+			return n
+		}
+		n.child = unsetRight(n.child, right[match:])
+		return n
+	case *branchNode:
+		if len(right) == 0 {
+			return n
+		}
+		idx := right[0]
+		for i := 0; i < int(idx); i++ {
+			n.children[i] = nil
+		}
+		n.children[idx] = unsetRight(n.children[idx], right[1:])
+		return n
+	default:
+		return n
+	}
+}
+
+// hasMore reports whether the (partially opaque) skeleton below n could
+// hold any key ordering after lastNibbles: an unexplored sibling with a
+// greater nibble at any branch along the path means yes.
+func hasMore(n node, lastNibbles []byte) bool {
+	switch n := n.(type) {
+	case nil:
+		return false
+	case *leafNode:
+		return bytes.Compare(n.path, lastNibbles) > 0
+	case *extensionNode:
+		match := prefixLen(lastNibbles, n.path)
+		if match < len(n.path) {
+			return match == len(lastNibbles) || n.path[match] > lastNibbles[match]
+		}
+		return hasMore(n.child, lastNibbles[match:])
+	case *branchNode:
+		if len(lastNibbles) == 0 {
+			for _, c := range n.children {
+				if c != nil {
+					return true
+				}
+			}
+			return false
+		}
+		idx := lastNibbles[0]
+		for i := int(idx) + 1; i < 16; i++ {
+			if n.children[i] != nil {
+				return true
+			}
+		}
+		return hasMore(n.children[idx], lastNibbles[1:])
+	}
+	return false
+}
@@ -0,0 +1,155 @@
+package trie
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// memStore is a minimal in-memory NodeStore used only to exercise
+// RangeProof/VerifyRangeProof without standing up a real backend.
+type memStore struct {
+	data map[string][]byte
+}
+
+func newMemStore() NodeStore {
+	return &memStore{data: make(map[string][]byte)}
+}
+
+func (s *memStore) Get(hash []byte) ([]byte, error) {
+	v, ok := s.data[string(hash)]
+	if !ok {
+		return nil, errors.New("memStore: not found")
+	}
+	return v, nil
+}
+
+func (s *memStore) Put(hash, blob []byte) error {
+	s.data[string(hash)] = blob
+	return nil
+}
+
+func (s *memStore) Delete(hash []byte) error {
+	delete(s.data, string(hash))
+	return nil
+}
+
+func (s *memStore) NewBatch() Batch {
+	return &memBatch{store: s}
+}
+
+func (s *memStore) Iterator(prefix []byte) Iterator {
+	var keys []string
+	for k := range s.data {
+		if strings.HasPrefix(k, string(prefix)) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return &memIterator{store: s, keys: keys, idx: -1}
+}
+
+type memBatch struct {
+	store *memStore
+	puts  map[string][]byte
+	dels  map[string]bool
+}
+
+func (b *memBatch) Put(hash, blob []byte) error {
+	if b.puts == nil {
+		b.puts = make(map[string][]byte)
+	}
+	b.puts[string(hash)] = blob
+	return nil
+}
+
+func (b *memBatch) Delete(hash []byte) error {
+	if b.dels == nil {
+		b.dels = make(map[string]bool)
+	}
+	b.dels[string(hash)] = true
+	return nil
+}
+
+func (b *memBatch) Write() error {
+	for k, v := range b.puts {
+		b.store.data[k] = v
+	}
+	for k := range b.dels {
+		delete(b.store.data, k)
+	}
+	return nil
+}
+
+func (b *memBatch) Reset() {
+	b.puts = nil
+	b.dels = nil
+}
+
+type memIterator struct {
+	store *memStore
+	keys  []string
+	idx   int
+}
+
+func (it *memIterator) Next() bool {
+	it.idx++
+	return it.idx < len(it.keys)
+}
+
+func (it *memIterator) Key() []byte   { return []byte(it.keys[it.idx]) }
+func (it *memIterator) Value() []byte { return it.store.data[it.keys[it.idx]] }
+func (it *memIterator) Error() error  { return nil }
+func (it *memIterator) Release()      {}
+
+// TestVerifyRangeProofRejectsDroppedMiddleKey guards against the proof
+// skeleton silently covering for a withheld in-range key: an internal
+// subtree between the two boundary paths must be rebuilt entirely from the
+// supplied keys, not left standing in as its old (still-valid-looking)
+// hashNode shortcut.
+func TestVerifyRangeProofRejectsDroppedMiddleKey(t *testing.T) {
+	store := newMemStore()
+	tr, err := New(nil, store, HashScheme)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	var keys [][]byte
+	for i := 10; i < 20; i++ {
+		k := []byte(fmt.Sprintf("key%d", i))
+		tr.Put(k, []byte(fmt.Sprintf("value%d", i)))
+		keys = append(keys, k)
+	}
+	root, _, err := tr.Commit(false)
+	if err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+
+	proof, pkeys, pvalues, err := tr.RangeProof(keys[0], keys[len(keys)-1])
+	if err != nil {
+		t.Fatalf("RangeProof: %v", err)
+	}
+
+	if _, err := VerifyRangeProof(root, keys[0], keys[len(keys)-1], pkeys, pvalues, proof); err != nil {
+		t.Fatalf("honest range proof failed to verify: %v", err)
+	}
+
+	idx := -1
+	for i, k := range pkeys {
+		if string(k) == "key13" {
+			idx = i
+			break
+		}
+	}
+	if idx == -1 {
+		t.Fatal("key13 not found among the range proof's keys")
+	}
+	droppedKeys := append(append([][]byte{}, pkeys[:idx]...), pkeys[idx+1:]...)
+	droppedValues := append(append([][]byte{}, pvalues[:idx]...), pvalues[idx+1:]...)
+
+	if _, err := VerifyRangeProof(root, keys[0], keys[len(keys)-1], droppedKeys, droppedValues, proof); err == nil {
+		t.Fatal("expected verification to fail when a middle key is withheld from the range")
+	}
+}
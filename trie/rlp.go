@@ -0,0 +1,42 @@
+package trie
+
+// A hand-rolled, trie-node-only RLP encoder. The trie only ever needs to
+// encode byte strings and fixed-arity lists of already-encoded items, so we
+// don't pull in a general-purpose RLP package for that.
+
+// rlpEncodeBytes RLP-encodes a single byte string.
+func rlpEncodeBytes(b []byte) []byte {
+	if len(b) == 1 && b[0] < 0x80 {
+		return b
+	}
+	return append(rlpEncodeLength(len(b), 0x80), b...)
+}
+
+// rlpEncodeList wraps already RLP-encoded items in a list header.
+func rlpEncodeList(items ...[]byte) []byte {
+	var body []byte
+	for _, item := range items {
+		body = append(body, item...)
+	}
+	return append(rlpEncodeLength(len(body), 0xc0), body...)
+}
+
+func rlpEncodeLength(size int, offset byte) []byte {
+	if size < 56 {
+		return []byte{offset + byte(size)}
+	}
+	lenBytes := bigEndianMinimal(uint64(size))
+	return append([]byte{offset + 55 + byte(len(lenBytes))}, lenBytes...)
+}
+
+func bigEndianMinimal(n uint64) []byte {
+	var buf []byte
+	for n > 0 {
+		buf = append([]byte{byte(n)}, buf...)
+		n >>= 8
+	}
+	if len(buf) == 0 {
+		buf = []byte{0}
+	}
+	return buf
+}
@@ -0,0 +1,63 @@
+package trie
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+)
+
+// Scheme selects how RadixTrie nodes are addressed in the backing store.
+type Scheme int
+
+const (
+	// HashScheme stores each node under its content hash (the original
+	// layout): updating a key's value produces a new row rather than
+	// overwriting the old one, so every historical root stays reachable
+	// for as long as its nodes aren't garbage collected, at the cost of
+	// unbounded row growth under repeated writes.
+	HashScheme Scheme = iota
+	// PathScheme stores each node under its trie path instead of its
+	// hash, so updating a key overwrites the same row and on-disk size
+	// tracks the live trie rather than its full history. Only the commits
+	// still held in the in-memory revert log can be undone, via Rollback.
+	PathScheme
+)
+
+func (s Scheme) String() string {
+	switch s {
+	case HashScheme:
+		return "hash"
+	case PathScheme:
+		return "path"
+	default:
+		return "unknown"
+	}
+}
+
+// SchemeMarker records which Scheme a database was first opened with, so a
+// later process can't reinterpret PathScheme rows (keyed by path) as
+// HashScheme rows (keyed by hash), or vice versa.
+type SchemeMarker struct {
+	gorm.Model
+	Scheme int
+}
+
+// ValidateScheme checks that db was previously opened with scheme, stamping
+// it with scheme on first use. It returns an error if db already carries a
+// marker for a different scheme.
+func ValidateScheme(db *gorm.DB, scheme Scheme) error {
+	db.AutoMigrate(&SchemeMarker{})
+
+	var marker SchemeMarker
+	err := db.Order("id").First(&marker).Error
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return db.Create(&SchemeMarker{Scheme: int(scheme)}).Error
+	case err != nil:
+		return err
+	case Scheme(marker.Scheme) != scheme:
+		return errors.New("trie: database was written with a different storage scheme")
+	default:
+		return nil
+	}
+}
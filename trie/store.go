@@ -0,0 +1,164 @@
+package trie
+
+import (
+	"database/sql"
+	"encoding/hex"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// NodeStore is the key/value interface Database needs from its backing
+// store: blobs in (or out) under the keys Database builds, with batched writes so a
+// Commit doesn't have to pay for one round-trip per node.
+type NodeStore interface {
+	Get(hash []byte) ([]byte, error)
+	Put(hash, blob []byte) error
+	Delete(hash []byte) error
+	NewBatch() Batch
+	Iterator(prefix []byte) Iterator
+}
+
+// Batch collects writes to apply in one round-trip to the store.
+type Batch interface {
+	Put(hash, blob []byte) error
+	Delete(hash []byte) error
+	Write() error
+	Reset()
+}
+
+// Iterator walks a NodeStore's keys in order, restricted to those with a
+// given prefix. Call Next before the first Key/Value, and Release once
+// done with it.
+type Iterator interface {
+	Next() bool
+	Key() []byte
+	Value() []byte
+	Error() error
+	Release()
+}
+
+// gormStore is the original relational backend: one row per stored key,
+// keyed by that key's hex encoding. owner is only used to populate Entry's Owner
+// column for queryability; the key itself is already namespaced by
+// Database before it ever reaches the store.
+type gormStore struct {
+	db    *gorm.DB
+	owner []byte
+}
+
+// NewGormStore wraps db (as opened by NewDatabase) as a NodeStore.
+func NewGormStore(db *gorm.DB, owner []byte) NodeStore {
+	return &gormStore{db: db, owner: owner}
+}
+
+func (s *gormStore) Get(hash []byte) ([]byte, error) {
+	var entry Entry
+	if err := s.db.Where("owner = ? AND key = ?", s.owner, hex.EncodeToString(hash)).First(&entry).Error; err != nil {
+		return nil, err
+	}
+	return entry.Value, nil
+}
+
+func (s *gormStore) Put(hash, blob []byte) error {
+	key := hex.EncodeToString(hash)
+	return s.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "owner"}, {Name: "key"}},
+		DoUpdates: clause.AssignmentColumns([]string{"value"}),
+	}).Create(&Entry{Owner: s.owner, Key: key, Value: blob}).Error
+}
+
+func (s *gormStore) Delete(hash []byte) error {
+	return s.db.Unscoped().Where("owner = ? AND key = ?", s.owner, hex.EncodeToString(hash)).Delete(&Entry{}).Error
+}
+
+func (s *gormStore) NewBatch() Batch {
+	return &gormBatch{store: s}
+}
+
+func (s *gormStore) Iterator(prefix []byte) Iterator {
+	rows, err := s.db.Model(&Entry{}).
+		Where("owner = ? AND key LIKE ?", s.owner, hex.EncodeToString(prefix)+"%").
+		Order("key").Rows()
+	return &gormIterator{db: s.db, rows: rows, err: err}
+}
+
+// gormBatch buffers puts/deletes and applies them as a single transaction.
+type gormBatch struct {
+	store *gormStore
+	puts  []Entry
+	dels  []string
+}
+
+func (b *gormBatch) Put(hash, blob []byte) error {
+	b.puts = append(b.puts, Entry{Owner: b.store.owner, Key: hex.EncodeToString(hash), Value: blob})
+	return nil
+}
+
+func (b *gormBatch) Delete(hash []byte) error {
+	b.dels = append(b.dels, hex.EncodeToString(hash))
+	return nil
+}
+
+func (b *gormBatch) Write() error {
+	return b.store.db.Transaction(func(tx *gorm.DB) error {
+		for _, e := range b.puts {
+			e := e
+			if err := tx.Clauses(clause.OnConflict{
+				Columns:   []clause.Column{{Name: "owner"}, {Name: "key"}},
+				DoUpdates: clause.AssignmentColumns([]string{"value"}),
+			}).Create(&e).Error; err != nil {
+				return err
+			}
+		}
+		for _, key := range b.dels {
+			if err := tx.Unscoped().Where("owner = ? AND key = ?", b.store.owner, key).Delete(&Entry{}).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (b *gormBatch) Reset() {
+	b.puts = nil
+	b.dels = nil
+}
+
+// gormIterator adapts *sql.Rows to the Iterator interface.
+type gormIterator struct {
+	db         *gorm.DB
+	rows       *sql.Rows
+	err        error
+	key, value []byte
+}
+
+func (it *gormIterator) Next() bool {
+	if it.err != nil || it.rows == nil {
+		return false
+	}
+	if !it.rows.Next() {
+		return false
+	}
+	var entry Entry
+	if err := it.db.ScanRows(it.rows, &entry); err != nil {
+		it.err = err
+		return false
+	}
+	key, err := hex.DecodeString(entry.Key)
+	if err != nil {
+		it.err = err
+		return false
+	}
+	it.key, it.value = key, entry.Value
+	return true
+}
+
+func (it *gormIterator) Key() []byte   { return it.key }
+func (it *gormIterator) Value() []byte { return it.value }
+func (it *gormIterator) Error() error  { return it.err }
+func (it *gormIterator) Release() {
+	if it.rows != nil {
+		it.rows.Close()
+	}
+}
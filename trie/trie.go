@@ -0,0 +1,513 @@
+// Package trie implements a Merkle-Patricia Trie compatible with the
+// Ethereum state trie layout: keys are nibble-split, paths are compressed
+// through extension nodes, nodes are RLP-encoded and addressed by their
+// keccak256 hash (or embedded inline when short enough), and the root is a
+// canonical commitment that any Ethereum-compatible verifier can check a
+// Proof against.
+package trie
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// ErrKeyNotFound is returned by Get, Del and Proof when the key has no
+// corresponding leaf in the trie.
+var ErrKeyNotFound = errors.New("key not found")
+
+// Trie is the interface implemented by RadixTrie.
+type Trie interface {
+	Get(key []byte) ([]byte, error)
+	Put(key []byte, value []byte)
+	Del(key []byte) error
+	Commit(collectLeaf bool) ([]byte, *NodeSet, error)
+	Proof(key []byte) ([][]byte, error)
+}
+
+// RadixTrie is a Merkle-Patricia Trie. The zero value is not usable; build
+// one with New, passing a NodeStore (such as one built with NewGormStore
+// or NewPebbleStore).
+type RadixTrie struct {
+	owner     []byte
+	root      node
+	db        *Database
+	scheme    Scheme
+	dirty     map[string]struct{} // keys touched since the last Commit
+	committed map[string][]byte   // path -> hash, as of the last Commit
+}
+
+// TrieID identifies one logical trie within a shared backing store: Owner
+// namespaces its nodes from every other trie in the same store (e.g. a
+// top-level accounts trie vs. a per-account storage trie), and Root is the
+// hash to resume from, or nil for a fresh, empty trie.
+type TrieID struct {
+	Owner []byte
+	Root  []byte
+}
+
+// Entry is the on-disk representation of a trie node, as written by
+// gormStore. Key is the hex-encoded namespaced key built by
+// Database.entryKey (owner||path||hash, or owner||path under PathScheme);
+// Owner is broken out into its own column, with a
+// composite unique index on (Owner, Key), so rows for one subtrie can be
+// queried or dropped without scanning every other subtrie sharing the
+// store.
+type Entry struct {
+	gorm.Model
+	Owner []byte `gorm:"index:idx_owner_key,unique"`
+	Key   string `gorm:"index:idx_owner_key,unique"`
+	Value []byte `gorm:"type:blob"`
+}
+
+// NewDatabase opens the SQLite-backed node store and migrates its schema.
+func NewDatabase() *gorm.DB {
+	db, err := gorm.Open(sqlite.Open("test.db"), &gorm.Config{})
+	if err != nil {
+		panic("failed to connect database")
+	}
+	db.AutoMigrate(&Entry{})
+	return db
+}
+
+// New returns the RadixTrie identified by id, through a dirty node cache
+// (see Database) rather than writing straight through to store, addressing
+// nodes according to scheme. A nil id, or one with a nil Root, yields a
+// fresh, empty trie; a non-nil Root is resolved by loading that node and
+// everything beneath it from store.
+func New(id *TrieID, store NodeStore, scheme Scheme) (*RadixTrie, error) {
+	if id == nil {
+		id = &TrieID{}
+	}
+	t := &RadixTrie{
+		owner:  id.Owner,
+		db:     NewDatabaseCache(store, id.Owner, scheme),
+		scheme: scheme,
+		dirty:  make(map[string]struct{}),
+	}
+	if len(id.Root) != 0 {
+		root, err := t.resolveNode(hex.EncodeToString(id.Root), nil)
+		if err != nil {
+			return nil, err
+		}
+		t.root = root
+	}
+	return t, nil
+}
+
+// resolveNode loads the node stored under hashHex at path and recursively
+// resolves every hashNode child beneath it, returning a fully materialized
+// subtree that Get/Put/Del can walk without any further store lookups.
+func (t *RadixTrie) resolveNode(hashHex string, path []byte) (node, error) {
+	blob, err := t.db.node(hashHex, path)
+	if err != nil {
+		return nil, err
+	}
+	n, err := decodeNode(blob)
+	if err != nil {
+		return nil, err
+	}
+	switch n := n.(type) {
+	case *branchNode:
+		for i, c := range n.children {
+			hn, ok := c.(hashNode)
+			if !ok {
+				continue
+			}
+			child, err := t.resolveNode(hex.EncodeToString(hn), concatNibbles(path, []byte{byte(i)}))
+			if err != nil {
+				return nil, err
+			}
+			n.children[i] = child
+		}
+		return n, nil
+	case *extensionNode:
+		hn, ok := n.child.(hashNode)
+		if !ok {
+			return n, nil
+		}
+		child, err := t.resolveNode(hex.EncodeToString(hn), concatNibbles(path, n.path))
+		if err != nil {
+			return nil, err
+		}
+		n.child = child
+		return n, nil
+	default:
+		return n, nil
+	}
+}
+
+// keyToNibbles converts a byte key into its nibble path, without the
+// terminator nibble keybytesToHex appends (termination is implied by
+// reaching a leafNode or a branchNode's value slot).
+func keyToNibbles(key []byte) []byte {
+	hex := keybytesToHex(key)
+	return hex[:len(hex)-1]
+}
+
+func (t *RadixTrie) Get(key []byte) ([]byte, error) {
+	value, ok := get(t.root, keyToNibbles(key))
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+	return value, nil
+}
+
+func (t *RadixTrie) Put(key []byte, value []byte) {
+	t.root = insert(t.root, keyToNibbles(key), value)
+	t.dirty[string(key)] = struct{}{}
+}
+
+func (t *RadixTrie) Del(key []byte) error {
+	newRoot, ok := remove(t.root, keyToNibbles(key))
+	if !ok {
+		return ErrKeyNotFound
+	}
+	t.root = newRoot
+	t.dirty[string(key)] = struct{}{}
+	return nil
+}
+
+// Commit RLP-encodes and keccak256-hashes every node reachable from the
+// root, flushes that root's subtree to the backing store, and returns the
+// new root hash together with a NodeSet describing every node inserted and
+// every node deleted since the last Commit (found by diffing this walk's
+// paths against the previous one). If collectLeaf is set, the NodeSet also
+// carries every (key, value) pair reachable in the committed trie.
+func (t *RadixTrie) Commit(collectLeaf bool) ([]byte, *NodeSet, error) {
+	set := newNodeSet(t.owner)
+
+	if t.root == nil {
+		for path, h := range t.committed {
+			set.Deletes[path] = h
+		}
+		if err := t.db.Commit(hex.EncodeToString(emptyRootHash()), pathsOf(set.Deletes)); err != nil {
+			return nil, nil, err
+		}
+		t.committed = nil
+		t.dirty = make(map[string]struct{})
+		return emptyRootHash(), set, nil
+	}
+
+	newPaths := make(map[string][]byte)
+	rootHex := t.commitNode(t.root, nil, set, newPaths, collectLeaf)
+	removed := make(map[string][]byte)
+	for path, h := range t.committed {
+		newHash, ok := newPaths[path]
+		if !ok {
+			// The path is gone entirely: its row needs removing on disk
+			// (under PathScheme) as well as being reported as deleted.
+			set.Deletes[path] = h
+			removed[path] = h
+			continue
+		}
+		if !bytes.Equal(newHash, h) {
+			// Same path, new value: the old hash-addressed blob is
+			// orphaned and must be reported so a pruner can collect it,
+			// but the row at this path was just overwritten with the new
+			// blob, so it must not also be queued for on-disk deletion.
+			set.Deletes[path] = h
+		}
+	}
+
+	if err := t.db.Commit(rootHex, pathsOf(removed)); err != nil {
+		return nil, nil, err
+	}
+	t.committed = newPaths
+	t.dirty = make(map[string]struct{})
+	root, _ := hex.DecodeString(rootHex)
+	return root, set, nil
+}
+
+// pathsOf returns the keys of a path->hash map as raw path byte slices, for
+// handing to Database.Commit.
+func pathsOf(deletes map[string][]byte) [][]byte {
+	if len(deletes) == 0 {
+		return nil
+	}
+	paths := make([][]byte, 0, len(deletes))
+	for path := range deletes {
+		paths = append(paths, []byte(path))
+	}
+	return paths
+}
+
+// Rollback restores the trie's backing store to the state as of the commit
+// that produced root, undoing every commit made since. Only supported
+// under PathScheme (see Database.Rollback); callers using HashScheme can
+// instead open a fresh RadixTrie at the old root with New.
+func (t *RadixTrie) Rollback(root []byte) error {
+	return t.db.Rollback(hex.EncodeToString(root))
+}
+
+// Cap spills the oldest dirty nodes to disk until the in-memory dirty set
+// is back under limit bytes.
+func (t *RadixTrie) Cap(limit int) error {
+	return t.db.Cap(limit)
+}
+
+// commitNode registers n and everything below it with the dirty node
+// cache and with set, recording path (the nibbles from the root down to n)
+// so it can be namespaced on disk, and returns n's hex-encoded hash.
+func (t *RadixTrie) commitNode(n node, path []byte, set *NodeSet, newPaths map[string][]byte, collectLeaf bool) string {
+	var refs []string
+	switch n := n.(type) {
+	case *branchNode:
+		for i, c := range n.children {
+			if c != nil {
+				refs = append(refs, t.commitNode(c, concatNibbles(path, []byte{byte(i)}), set, newPaths, collectLeaf))
+			}
+		}
+		if collectLeaf && n.value != nil {
+			set.Leaves = append(set.Leaves, LeafInsert{Key: hexToKeybytes(path), Value: n.value})
+		}
+	case *extensionNode:
+		refs = append(refs, t.commitNode(n.child, concatNibbles(path, n.path), set, newPaths, collectLeaf))
+	case *leafNode:
+		if collectLeaf {
+			set.Leaves = append(set.Leaves, LeafInsert{Key: hexToKeybytes(concatNibbles(path, n.path)), Value: n.value})
+		}
+	}
+
+	enc := n.encode()
+	hash := keccak256(enc)
+	hashHex := hex.EncodeToString(hash)
+	t.db.insert(hashHex, enc, refs, path)
+
+	pathKey := string(path)
+	if old, ok := t.committed[pathKey]; !ok || !bytes.Equal(old, hash) {
+		set.Inserts[pathKey] = &NodeInsert{Hash: hash, Blob: enc}
+	}
+	newPaths[pathKey] = hash
+	return hashHex
+}
+
+func emptyRootHash() []byte {
+	return keccak256(rlpEncodeBytes(nil))
+}
+
+// Proof returns the RLP encoding of every node on the path from the root to
+// key's leaf, in order. Any Ethereum-compatible verifier can check this
+// proof against the trie's root hash.
+func (t *RadixTrie) Proof(key []byte) ([][]byte, error) {
+	nibbles := keyToNibbles(key)
+	var proof [][]byte
+	cur := t.root
+	for {
+		switch n := cur.(type) {
+		case nil:
+			return nil, ErrKeyNotFound
+		case *leafNode:
+			if !bytes.Equal(nibbles, n.path) {
+				return nil, ErrKeyNotFound
+			}
+			proof = append(proof, n.encode())
+			return proof, nil
+		case *extensionNode:
+			match := prefixLen(nibbles, n.path)
+			if match < len(n.path) {
+				return nil, ErrKeyNotFound
+			}
+			proof = append(proof, n.encode())
+			nibbles = nibbles[match:]
+			cur = n.child
+		case *branchNode:
+			proof = append(proof, n.encode())
+			if len(nibbles) == 0 {
+				if n.value == nil {
+					return nil, ErrKeyNotFound
+				}
+				return proof, nil
+			}
+			cur = n.children[nibbles[0]]
+			nibbles = nibbles[1:]
+		}
+	}
+}
+
+// get walks n looking for the value stored at key (a nibble path).
+func get(n node, key []byte) ([]byte, bool) {
+	switch n := n.(type) {
+	case nil:
+		return nil, false
+	case *leafNode:
+		if bytes.Equal(key, n.path) {
+			return n.value, true
+		}
+		return nil, false
+	case *extensionNode:
+		match := prefixLen(key, n.path)
+		if match < len(n.path) {
+			return nil, false
+		}
+		return get(n.child, key[match:])
+	case *branchNode:
+		if len(key) == 0 {
+			return n.value, n.value != nil
+		}
+		return get(n.children[key[0]], key[1:])
+	}
+	panic("unreachable node type")
+}
+
+// insert writes value at key (a nibble path), splitting and growing
+// extension/branch nodes as needed to keep the trie canonical.
+func insert(n node, key []byte, value []byte) node {
+	if n == nil {
+		return &leafNode{path: append([]byte{}, key...), value: value}
+	}
+
+	switch n := n.(type) {
+	case *leafNode:
+		match := prefixLen(key, n.path)
+		if match == len(n.path) && match == len(key) {
+			n.value = value
+			return n
+		}
+		branch := &branchNode{}
+		if match == len(n.path) {
+			branch.value = n.value
+		} else {
+			branch.children[n.path[match]] = &leafNode{path: n.path[match+1:], value: n.value}
+		}
+		if match == len(key) {
+			branch.value = value
+		} else {
+			branch.children[key[match]] = &leafNode{path: key[match+1:], value: value}
+		}
+		if match == 0 {
+			return branch
+		}
+		return &extensionNode{path: key[:match], child: branch}
+
+	case *extensionNode:
+		match := prefixLen(key, n.path)
+		if match == len(n.path) {
+			n.child = insert(n.child, key[match:], value)
+			return n
+		}
+		branch := &branchNode{}
+		if match == len(n.path)-1 {
+			branch.children[n.path[match]] = n.child
+		} else {
+			branch.children[n.path[match]] = &extensionNode{path: n.path[match+1:], child: n.child}
+		}
+		if match == len(key) {
+			branch.value = value
+		} else {
+			branch.children[key[match]] = &leafNode{path: key[match+1:], value: value}
+		}
+		if match == 0 {
+			return branch
+		}
+		return &extensionNode{path: key[:match], child: branch}
+
+	case *branchNode:
+		if len(key) == 0 {
+			n.value = value
+			return n
+		}
+		c := key[0]
+		n.children[c] = insert(n.children[c], key[1:], value)
+		return n
+
+	case hashNode:
+		// An unresolved subtree: only reachable while reconstructing a
+		// range proof, where the caller guarantees every key under it is
+		// being supplied, so it's safe to rebuild it from scratch.
+		return insert(nil, key, value)
+	}
+	panic("unreachable node type")
+}
+
+// remove deletes key (a nibble path) from n, collapsing branch/extension
+// nodes that are left with a single remaining child so the trie stays
+// canonical. The bool result reports whether key was present.
+func remove(n node, key []byte) (node, bool) {
+	switch n := n.(type) {
+	case nil:
+		return nil, false
+
+	case *leafNode:
+		if !bytes.Equal(key, n.path) {
+			return n, false
+		}
+		return nil, true
+
+	case *extensionNode:
+		match := prefixLen(key, n.path)
+		if match < len(n.path) {
+			return n, false
+		}
+		child, ok := remove(n.child, key[match:])
+		if !ok {
+			return n, false
+		}
+		switch child := child.(type) {
+		case nil:
+			return nil, true
+		case *leafNode:
+			return &leafNode{path: concatNibbles(n.path, child.path), value: child.value}, true
+		case *extensionNode:
+			return &extensionNode{path: concatNibbles(n.path, child.path), child: child.child}, true
+		default:
+			n.child = child
+			return n, true
+		}
+
+	case *branchNode:
+		if len(key) == 0 {
+			if n.value == nil {
+				return n, false
+			}
+			n.value = nil
+		} else {
+			child, ok := remove(n.children[key[0]], key[1:])
+			if !ok {
+				return n, false
+			}
+			n.children[key[0]] = child
+		}
+		return collapseBranch(n), true
+	}
+	panic("unreachable node type")
+}
+
+// collapseBranch turns a branch left with a single child (and no value) or
+// a single value (and no children) into the equivalent leaf/extension node,
+// which is what keeps the trie canonical after a deletion.
+func collapseBranch(n *branchNode) node {
+	pos, count := -1, 0
+	for i, c := range n.children {
+		if c != nil {
+			count++
+			pos = i
+		}
+	}
+	if count == 0 && n.value == nil {
+		return nil
+	}
+	if count == 0 {
+		return &leafNode{path: []byte{}, value: n.value}
+	}
+	if count == 1 && n.value == nil {
+		switch child := n.children[pos].(type) {
+		case *leafNode:
+			return &leafNode{path: concatNibbles([]byte{byte(pos)}, child.path), value: child.value}
+		case *extensionNode:
+			return &extensionNode{path: concatNibbles([]byte{byte(pos)}, child.path), child: child.child}
+		default:
+			return &extensionNode{path: []byte{byte(pos)}, child: child}
+		}
+	}
+	return n
+}
+
+func concatNibbles(a, b []byte) []byte {
+	out := make([]byte, 0, len(a)+len(b))
+	out = append(out, a...)
+	return append(out, b...)
+}